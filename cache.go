@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"io"
+	"io/fs"
+	"sync"
+	"time"
+)
+
+// fileEntry abstracts over where a served file's bytes actually live: fully
+// resident in memory (memFile) or streamed from disk on demand (lazyFile).
+type fileEntry interface {
+	ContentType() string
+	ETag() string
+	ModTime() time.Time
+	Size() int64
+	// Open returns a fresh, independently seekable reader over the file's
+	// identity (uncompressed) bytes.
+	Open() (io.ReadSeekCloser, error)
+	// Encoded returns precomputed bytes for the given content-coding
+	// ("gzip" or "br"), if this entry has them cached.
+	Encoded(encoding string) ([]byte, bool)
+}
+
+// memFile holds a file fully in memory, along with precomputed gzip/brotli
+// variants, so repeat requests never touch the disk or a compressor again.
+type memFile struct {
+	content     []byte
+	contentGzip []byte
+	contentBr   []byte
+	contentType string
+	etag        string
+	modTime     time.Time
+	fileSize    int64
+}
+
+func (f *memFile) ContentType() string { return f.contentType }
+func (f *memFile) ETag() string        { return f.etag }
+func (f *memFile) ModTime() time.Time  { return f.modTime }
+func (f *memFile) Size() int64         { return f.fileSize }
+
+func (f *memFile) Encoded(encoding string) ([]byte, bool) {
+	switch encoding {
+	case "br":
+		return f.contentBr, f.contentBr != nil
+	case "gzip":
+		return f.contentGzip, f.contentGzip != nil
+	default:
+		return nil, false
+	}
+}
+
+func (f *memFile) Open() (io.ReadSeekCloser, error) {
+	return readSeekNopCloser{bytes.NewReader(f.content)}, nil
+}
+
+type readSeekNopCloser struct {
+	*bytes.Reader
+}
+
+func (readSeekNopCloser) Close() error { return nil }
+
+// lazyFile holds only metadata and re-reads its content from assets on every
+// request, trading request latency for a flat, small memory footprint.
+type lazyFile struct {
+	assets      fs.FS
+	path        string
+	contentType string
+	etag        string
+	modTime     time.Time
+	fileSize    int64
+	// alreadyCompressed records whether staticFilesToMap decided this file's
+	// bytes are already in a compressed format (images, video, fonts, ...),
+	// so a promotion to memFile knows to skip gzip/brotli too.
+	alreadyCompressed bool
+}
+
+func (f *lazyFile) ContentType() string             { return f.contentType }
+func (f *lazyFile) ETag() string                    { return f.etag }
+func (f *lazyFile) ModTime() time.Time              { return f.modTime }
+func (f *lazyFile) Size() int64                     { return f.fileSize }
+func (f *lazyFile) Encoded(_ string) ([]byte, bool) { return nil, false }
+
+func (f *lazyFile) Open() (io.ReadSeekCloser, error) {
+	file, err := f.assets.Open(f.path)
+	if err != nil {
+		return nil, err
+	}
+	rsc, ok := file.(io.ReadSeekCloser)
+	if !ok {
+		file.Close()
+		return nil, fmt.Errorf("%s: underlying filesystem does not support seeking", f.path)
+	}
+	return rsc, nil
+}
+
+// lruCache keeps a budget's worth of cold lazyFiles promoted to hot,
+// fully-resident memFiles, based on actual request traffic: resolve is
+// called on every request, bumps the recency of whatever's already
+// resident, and promotes whatever isn't (reading and compressing it from
+// assets) so it stays resident until something more recently used needs
+// its share of the --max-cache-bytes budget. The most recently resolved
+// path is never evicted by its own promotion, so the cache always keeps
+// at least one file in memory.
+type lruCache struct {
+	mu       sync.Mutex
+	capacity int64
+	used     int64
+	order    *list.List
+	index    map[string]*list.Element
+}
+
+type lruCacheItem struct {
+	path  string
+	entry *memFile
+}
+
+func newLRUCache(capacityBytes int64) *lruCache {
+	return &lruCache{
+		capacity: capacityBytes,
+		order:    list.New(),
+		index:    make(map[string]*list.Element),
+	}
+}
+
+// resolve returns the fileEntry a request for lf should be served from: the
+// already-resident memFile, promoted to most-recently-used, or a freshly
+// built one if lf isn't resident yet. If lf can't be read (e.g. it was
+// removed from disk), resolve falls back to serving it lazily instead of
+// promoting it.
+//
+// Residency is keyed by lf.path, the underlying asset path, not the
+// request's URL path: staticFilesToMap maps several URL paths (e.g.
+// "/dir" and "/dir/index.html") to the same *lazyFile, and they must share
+// one cache slot rather than each promoting and budgeting their own copy.
+func (c *lruCache) resolve(lf *lazyFile) fileEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.index[lf.path]; ok {
+		c.order.MoveToBack(el)
+		return el.Value.(*lruCacheItem).entry
+	}
+
+	mf, err := buildMemFile(lf)
+	if err != nil {
+		return lf
+	}
+	c.admitLocked(lf.path, mf)
+	return mf
+}
+
+// admitLocked records path/mf as the most-recently-used resident and
+// evicts least-recently-used residents until the cache fits its byte
+// budget again. Eviction here just forgets the memFile: the path's
+// lazyFile in the static map is untouched, so the next request for it
+// streams from disk again until it's promoted back in.
+func (c *lruCache) admitLocked(path string, mf *memFile) {
+	el := c.order.PushBack(&lruCacheItem{path: path, entry: mf})
+	c.index[path] = el
+	c.used += mf.fileSize
+	for c.used > c.capacity && c.order.Len() > 1 {
+		front := c.order.Front()
+		item := front.Value.(*lruCacheItem)
+		c.order.Remove(front)
+		delete(c.index, item.path)
+		c.used -= item.entry.fileSize
+	}
+}
+
+// buildMemFile reads lf's full content and computes its compressed
+// variants, promoting a cold lazyFile into a hot, fully resident memFile.
+func buildMemFile(lf *lazyFile) (*memFile, error) {
+	reader, err := lf.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	mf := &memFile{
+		content:     content,
+		contentType: lf.contentType,
+		etag:        lf.etag,
+		modTime:     lf.modTime,
+		fileSize:    lf.fileSize,
+	}
+	if !lf.alreadyCompressed {
+		mf.contentGzip = compressGzip(content)
+		mf.contentBr = compressBrotli(content)
+	}
+	return mf, nil
+}
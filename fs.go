@@ -0,0 +1,18 @@
+//go:build !embed
+
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+)
+
+// rootFS resolves the filesystem to serve from the directory argument. This
+// build reads assets straight off disk, so directory is mandatory.
+func rootFS(directory string) (fs.FS, error) {
+	if directory == "" {
+		return nil, errors.New("required argument 'directory' not provided, try --help")
+	}
+	return os.DirFS(directory), nil
+}
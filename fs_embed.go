@@ -0,0 +1,18 @@
+//go:build embed
+
+package main
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed all:public
+var embeddedFiles embed.FS
+
+// rootFS resolves the filesystem to serve from the assets baked into the
+// binary at compile time via go:embed. The directory argument is ignored,
+// letting users ship a single self-contained executable for a fixed site.
+func rootFS(_ string) (fs.FS, error) {
+	return fs.Sub(embeddedFiles, "public")
+}
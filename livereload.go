@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const liveReloadScript = `<script>(function(){try{new EventSource("/__livereload").addEventListener("reload",function(){location.reload()})}catch(e){}})();</script>`
+
+// injectLiveReload appends the live-reload client snippet just before
+// </body>, or at the end of the document if there is no closing body tag.
+func injectLiveReload(content []byte) []byte {
+	script := []byte(liveReloadScript)
+	idx := bytes.LastIndex(content, []byte("</body>"))
+	if idx == -1 {
+		return append(content, script...)
+	}
+	out := make([]byte, 0, len(content)+len(script))
+	out = append(out, content[:idx]...)
+	out = append(out, script...)
+	out = append(out, content[idx:]...)
+	return out
+}
+
+// liveReloadHub fans out a "reload" notification to every connected
+// /__livereload SSE client.
+type liveReloadHub struct {
+	mu   sync.Mutex
+	subs map[chan struct{}]struct{}
+}
+
+func newLiveReloadHub() *liveReloadHub {
+	return &liveReloadHub{subs: make(map[chan struct{}]struct{})}
+}
+
+func (h *liveReloadHub) subscribe() chan struct{} {
+	ch := make(chan struct{}, 1)
+	h.mu.Lock()
+	h.subs[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch
+}
+
+func (h *liveReloadHub) unsubscribe(ch chan struct{}) {
+	h.mu.Lock()
+	delete(h.subs, ch)
+	h.mu.Unlock()
+}
+
+func (h *liveReloadHub) broadcast() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// serveLiveReload handles the /__livereload SSE endpoint, pushing a "reload"
+// event to this client whenever the hub broadcasts one.
+func serveLiveReload(hub *liveReloadHub, w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ch := hub.subscribe()
+	defer hub.unsubscribe(ch)
+
+	for {
+		select {
+		case <-ch:
+			fmt.Fprint(w, "event: reload\ndata: reload\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// watchAndReload watches directory for filesystem changes and, on each
+// change, rebuilds the static files map (and its LRU cache, if any) and
+// atomically swaps both into current/cache so in-flight requests keep using
+// a consistent snapshot.
+func watchAndReload(directory string, assets fs.FS, current *atomic.Pointer[files], cache *atomic.Pointer[lruCache], hub *liveReloadHub) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer watcher.Close()
+
+	watchDirs := func() {
+		filepath.WalkDir(directory, func(path string, d fs.DirEntry, err error) error {
+			if err == nil && d.IsDir() {
+				watcher.Add(path)
+			}
+			return nil
+		})
+	}
+	watchDirs()
+
+	rebuild := func() {
+		m, c := staticFilesToMap(assets)
+		current.Store(&m)
+		cache.Store(c)
+		watchDirs() // pick up newly created subdirectories
+		hub.broadcast()
+		log.Println("Reloaded static files")
+	}
+
+	var debounce *time.Timer
+	for {
+		select {
+		case _, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if debounce != nil {
+				debounce.Stop()
+			}
+			debounce = time.AfterFunc(100*time.Millisecond, rebuild)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Println("watch error:", err)
+		}
+	}
+}
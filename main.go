@@ -3,42 +3,81 @@ package main
 import (
 	"bytes"
 	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
 	"io/fs"
-	"io/ioutil"
 	"log"
+	"mime/multipart"
 	"net/http"
-	"path/filepath"
+	"net/textproto"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
+	"github.com/andybalholm/brotli"
 	"github.com/h2non/filetype"
 	"github.com/rs/cors"
 	"gopkg.in/alecthomas/kingpin.v2"
 )
 
 var (
-	staticDir = kingpin.Arg("directory", "Directory to serve").Required().String()
-	noCache   = kingpin.Flag("no-cache", "Remove cache control").Bool()
+	staticDir       = kingpin.Arg("directory", "Directory to serve").String()
+	noCache         = kingpin.Flag("no-cache", "Remove cache control").Bool()
+	spa             = kingpin.Flag("spa", "Serve /index.html for unmatched paths instead of 404 (single-page app mode)").Bool()
+	notFoundPage    = kingpin.Flag("404-page", "Path (as served, e.g. /404.html) to render on 404 instead of the default page").String()
+	serverErrorPage = kingpin.Flag("50x-page", "Path (as served, e.g. /50x.html) to render on server errors instead of the default page").String()
+	watch           = kingpin.Flag("watch", "Watch the directory for changes and live-reload connected browsers").Bool()
+	addr            = kingpin.Flag("addr", "Address to listen on").Default(":8888").String()
+	tlsCert         = kingpin.Flag("tls-cert", "Path to a TLS certificate file, use with --tls-key").String()
+	tlsKey          = kingpin.Flag("tls-key", "Path to a TLS private key file, use with --tls-cert").String()
+	autocertDomains = kingpin.Flag("autocert", "Comma-separated domains to obtain Let's Encrypt certificates for via ACME, serves on :443").String()
+	autocertCache   = kingpin.Flag("autocert-cache-dir", "Directory to cache autocert certificates in").Default("certs").String()
+	redirectHTTP    = kingpin.Flag("redirect-http", "Run an additional listener on :80 that redirects to https").Bool()
+	maxCacheBytes   = kingpin.Flag("max-cache-bytes", "Maximum bytes of file content to keep resident in memory; 0 keeps everything in memory, as before. Files evicted from the cache stream from disk per request").Default("0").Int64()
 )
 
 func main() {
 	kingpin.Version("0.0.1")
 	kingpin.Parse()
 
+	assets, err := rootFS(*staticDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	// Collect everything to map to make this just so fast
-	// Since not using mutex remember to never write to this map again after init
-	staticFiles := staticFilesToMap(*staticDir)
+	initial, initialCache := staticFilesToMap(assets)
+	var currentFiles atomic.Pointer[files]
+	currentFiles.Store(&initial)
+	var currentCache atomic.Pointer[lruCache]
+	currentCache.Store(initialCache)
 
 	log.Println("Found routes:")
-	for key := range staticFiles {
+	for key := range initial {
 		log.Println(key)
 	}
 
+	hub := newLiveReloadHub()
+	if *watch {
+		if *staticDir == "" {
+			log.Fatal("--watch requires a directory argument")
+		}
+		go watchAndReload(*staticDir, assets, &currentFiles, &currentCache, hub)
+		log.Printf("Watching %s for changes", *staticDir)
+	}
+
 	mux := http.NewServeMux()
+	mux.HandleFunc("/__livereload", func(w http.ResponseWriter, r *http.Request) {
+		serveLiveReload(hub, w, r)
+	})
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == http.MethodGet {
 			// Try to serve static file
-			serveStatic(staticFiles, w, r)
+			serveStatic(*currentFiles.Load(), currentCache.Load(), w, r)
 		} else {
 			w.WriteHeader(http.StatusMethodNotAllowed)
 			w.Write([]byte("method not allowed"))
@@ -50,31 +89,313 @@ func main() {
 		AllowedHeaders: []string{"*"},
 	})
 	handler := c.Handler(mux)
-	addr := ":8888"
-	log.Printf("Listening on %s", addr)
-	log.Fatal(http.ListenAndServe(addr, handler))
+	serve(handler)
 }
 
-func sendNotFound(w http.ResponseWriter) {
+func sendNotFound(f files, w http.ResponseWriter) {
+	if *notFoundPage != "" {
+		if value, ok := f[*notFoundPage]; ok {
+			writeWholeFile(w, value, http.StatusNotFound)
+			return
+		}
+	}
 	w.WriteHeader(http.StatusNotFound)
 	w.Write([]byte("not found"))
 }
 
-type fileInMap struct {
-	content     []byte
-	contentType string
+func sendServerError(f files, w http.ResponseWriter) {
+	if *serverErrorPage != "" {
+		if value, ok := f[*serverErrorPage]; ok {
+			writeWholeFile(w, value, http.StatusInternalServerError)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusInternalServerError)
+}
+
+func writeWholeFile(w http.ResponseWriter, value fileEntry, status int) {
+	reader, err := value.Open()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	defer reader.Close()
+	w.Header().Set("Content-Type", value.ContentType())
+	w.WriteHeader(status)
+	io.Copy(w, reader)
+}
+
+// isNotModified reports whether the request's conditional headers indicate
+// the client's cached copy is still fresh, per the If-None-Match /
+// If-Modified-Since precedence in RFC 7232.
+func isNotModified(r *http.Request, value fileEntry) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return inm == value.ETag()
+	}
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" {
+		t, err := time.Parse(http.TimeFormat, ims)
+		if err == nil && !value.ModTime().Truncate(time.Second).After(t) {
+			return true
+		}
+	}
+	return false
+}
+
+func compressGzip(content []byte) []byte {
+	var b bytes.Buffer
+	gz := gzip.NewWriter(&b)
+	if _, err := gz.Write(content); err != nil {
+		log.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		log.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func compressBrotli(content []byte) []byte {
+	var b bytes.Buffer
+	br := brotli.NewWriter(&b)
+	if _, err := br.Write(content); err != nil {
+		log.Fatal(err)
+	}
+	if err := br.Close(); err != nil {
+		log.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+// parseAcceptEncoding turns an Accept-Encoding header into a coding -> qvalue
+// map, as described in RFC 7231 section 5.3.4.
+func parseAcceptEncoding(header string) map[string]float64 {
+	weights := make(map[string]float64)
+	for _, part := range strings.Split(header, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		coding := part
+		weight := 1.0
+		if idx := strings.Index(part, ";"); idx != -1 {
+			coding = strings.TrimSpace(part[:idx])
+			if q := strings.TrimSpace(part[idx+1:]); strings.HasPrefix(q, "q=") {
+				if parsed, err := strconv.ParseFloat(strings.TrimPrefix(q, "q="), 64); err == nil {
+					weight = parsed
+				}
+			}
+		}
+		weights[coding] = weight
+	}
+	return weights
+}
+
+// acceptsEncoding reports whether coding is acceptable under weights,
+// falling back to the "*" wildcard when the coding isn't listed explicitly.
+func acceptsEncoding(weights map[string]float64, coding string) bool {
+	if q, ok := weights[coding]; ok {
+		return q > 0
+	}
+	if q, ok := weights["*"]; ok {
+		return q > 0
+	}
+	return false
+}
+
+// openEncoded picks the best content-coding to serve for value, preferring
+// brotli over gzip over identity, honoring the client's Accept-Encoding
+// q-values and identity;q=0 exclusion, and opens a reader over the result.
+// Precomputed encodings only exist on in-memory entries; lazily-streamed
+// entries always serve identity bytes straight off disk.
+func openEncoded(header string, value fileEntry) (encoding string, reader io.ReadCloser, length int64, err error) {
+	if header != "" {
+		weights := parseAcceptEncoding(header)
+		if buf, ok := value.Encoded("br"); ok && acceptsEncoding(weights, "br") {
+			return "br", io.NopCloser(bytes.NewReader(buf)), int64(len(buf)), nil
+		}
+		if buf, ok := value.Encoded("gzip"); ok && acceptsEncoding(weights, "gzip") {
+			return "gzip", io.NopCloser(bytes.NewReader(buf)), int64(len(buf)), nil
+		}
+	}
+	identity, err := value.Open()
+	if err != nil {
+		return "", nil, 0, err
+	}
+	return "", identity, value.Size(), nil
+}
+
+// httpRange is a single byte range resolved against a known content size.
+type httpRange struct {
+	start  int64
+	length int64
+}
+
+// errInvalidRange means the Range header's syntax couldn't be parsed at all;
+// per RFC 7233 section 2.1 this must be ignored, not answered with 416.
+// errNoOverlap means the header parsed fine but no range in it overlaps the
+// resource, which is the one case 416 is for.
+var (
+	errInvalidRange = errors.New("invalid range")
+	errNoOverlap    = errors.New("no overlapping range")
+)
+
+// parseByteRanges parses the value of a Range header ("bytes=0-499,500-999")
+// into concrete, size-bounded ranges, following RFC 7233 section 2.1.
+func parseByteRanges(header string, size int64) ([]httpRange, error) {
+	const prefix = "bytes="
+	if !strings.HasPrefix(header, prefix) {
+		return nil, errInvalidRange
+	}
+	var ranges []httpRange
+	for _, ra := range strings.Split(strings.TrimPrefix(header, prefix), ",") {
+		ra = strings.TrimSpace(ra)
+		if ra == "" {
+			continue
+		}
+		start, end, ok := strings.Cut(ra, "-")
+		if !ok {
+			return nil, errInvalidRange
+		}
+		start, end = strings.TrimSpace(start), strings.TrimSpace(end)
+		var r httpRange
+		if start == "" {
+			// Suffix range: the last N bytes of the content.
+			if end == "" {
+				return nil, errInvalidRange
+			}
+			n, err := strconv.ParseInt(end, 10, 64)
+			if err != nil || n < 0 {
+				return nil, errInvalidRange
+			}
+			if n > size {
+				n = size
+			}
+			r.start = size - n
+			r.length = n
+		} else {
+			i, err := strconv.ParseInt(start, 10, 64)
+			if err != nil || i < 0 {
+				return nil, errInvalidRange
+			}
+			if i >= size {
+				// Unsatisfiable on its own; only an error if no range overlaps.
+				continue
+			}
+			r.start = i
+			if end == "" {
+				r.length = size - i
+			} else {
+				j, err := strconv.ParseInt(end, 10, 64)
+				if err != nil || i > j {
+					return nil, errInvalidRange
+				}
+				if j >= size {
+					j = size - 1
+				}
+				r.length = j - i + 1
+			}
+		}
+		ranges = append(ranges, r)
+	}
+	if len(ranges) == 0 {
+		return nil, errNoOverlap
+	}
+	return ranges, nil
+}
+
+func contentRangeHeader(r httpRange, size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+// sendRange writes a 206 (or 416) response for a Range request, always
+// against the identity bytes since ranges and on-the-fly compression don't
+// mix. It reports whether it wrote a response at all: a Range header with
+// invalid syntax is not an error per RFC 7233 section 2.1, so it reports
+// false and leaves the caller to fall back to a normal full-body response.
+func sendRange(f files, w http.ResponseWriter, value fileEntry, header string) bool {
+	ranges, err := parseByteRanges(header, value.Size())
+	if err != nil {
+		if !errors.Is(err, errNoOverlap) {
+			return false
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", value.Size()))
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		return true
+	}
+
+	reader, err := value.Open()
+	if err != nil {
+		sendServerError(f, w)
+		return true
+	}
+	defer reader.Close()
+
+	if len(ranges) == 1 {
+		ra := ranges[0]
+		if _, err := reader.Seek(ra.start, io.SeekStart); err != nil {
+			sendServerError(f, w)
+			return true
+		}
+		w.Header().Set("Content-Range", contentRangeHeader(ra, value.Size()))
+		w.Header().Set("Content-Length", strconv.FormatInt(ra.length, 10))
+		w.WriteHeader(http.StatusPartialContent)
+		io.CopyN(w, reader, ra.length)
+		return true
+	}
+
+	// Multipart responses need a Content-Length up front, so buffer the parts
+	// rather than streaming several seeks straight to w.
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for _, ra := range ranges {
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":  {value.ContentType()},
+			"Content-Range": {contentRangeHeader(ra, value.Size())},
+		})
+		if err != nil {
+			sendServerError(f, w)
+			return true
+		}
+		if _, err := reader.Seek(ra.start, io.SeekStart); err != nil {
+			sendServerError(f, w)
+			return true
+		}
+		if _, err := io.CopyN(part, reader, ra.length); err != nil {
+			sendServerError(f, w)
+			return true
+		}
+	}
+	mw.Close()
+
+	w.Header().Set("Content-Type", "multipart/byteranges; boundary="+mw.Boundary())
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.WriteHeader(http.StatusPartialContent)
+	w.Write(buf.Bytes())
+	return true
 }
 
-type files map[string]fileInMap
+type files map[string]fileEntry
 
-func staticFilesToMap(directory string) files {
+// staticFilesToMap walks assets once at startup (or on every --watch
+// rebuild) and resolves each file to a fileEntry. With --max-cache-bytes
+// unset, every file is loaded fully into memory with precomputed gzip/brotli
+// variants, exactly as before. With a budget set, every path starts out as a
+// lazyFile that streams from disk, and the returned lruCache promotes paths
+// to resident memFiles as serveStatic resolves real requests against it.
+func staticFilesToMap(assets fs.FS) (files, *lruCache) {
 	m := make(files)
-	filepath.WalkDir(directory, func(path string, d fs.DirEntry, err error) error {
+
+	var cache *lruCache
+	if *maxCacheBytes > 0 {
+		cache = newLRUCache(*maxCacheBytes)
+	}
+
+	fs.WalkDir(assets, ".", func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			log.Fatal(err)
 		}
 		if !d.IsDir() {
 			var contentType string
+			var alreadyCompressed bool
 			splitted := strings.Split(path, ".")
 			if len(splitted) != 0 {
 				extension := splitted[len(splitted)-1]
@@ -95,7 +416,7 @@ func staticFilesToMap(directory string) files {
 			}
 
 			if contentType == "" {
-				buf, err := ioutil.ReadFile(path)
+				buf, err := fs.ReadFile(assets, path)
 				if err != nil {
 					log.Fatal(err)
 				}
@@ -108,63 +429,129 @@ func staticFilesToMap(directory string) files {
 				} else {
 					contentType = "text/plain"
 				}
+				// Images, video, audio, fonts and archives already carry their
+				// own compression, so gzip/brotli would only cost CPU for no gain.
+				alreadyCompressed = filetype.IsImage(buf) || filetype.IsVideo(buf) ||
+					filetype.IsAudio(buf) || filetype.IsFont(buf) || filetype.IsArchive(buf)
 			}
-			content, err := ioutil.ReadFile(path)
+			content, err := fs.ReadFile(assets, path)
 			if err != nil {
 				log.Fatal(err)
 			}
-			formattedPath := strings.Replace(path, directory, "", 1)
-			m[formattedPath] = fileInMap{
-				content:     content,
-				contentType: contentType,
+			if *watch && contentType == "text/html" {
+				content = injectLiveReload(content)
 			}
-			// If index.html also navigate through dir root
-			if strings.HasSuffix(formattedPath, "index.html") {
-				rootPath := strings.TrimSuffix(formattedPath, "/index.html")
-				m[rootPath] = fileInMap{
+			info, err := d.Info()
+			if err != nil {
+				log.Fatal(err)
+			}
+			sum := sha256.Sum256(content)
+			etag := `"` + hex.EncodeToString(sum[:]) + `"`
+
+			lf := &lazyFile{
+				assets:            assets,
+				path:              path,
+				contentType:       contentType,
+				etag:              etag,
+				modTime:           info.ModTime(),
+				fileSize:          info.Size(),
+				alreadyCompressed: alreadyCompressed,
+			}
+
+			var entry fileEntry = lf
+			if cache == nil {
+				mf := &memFile{
 					content:     content,
 					contentType: contentType,
+					etag:        etag,
+					modTime:     info.ModTime(),
+					fileSize:    info.Size(),
 				}
+				if !alreadyCompressed {
+					mf.contentGzip = compressGzip(content)
+					mf.contentBr = compressBrotli(content)
+				}
+				entry = mf
+			}
+
+			formattedPath := "/" + path
+			m[formattedPath] = entry
+			// If index.html also navigate through dir root
+			if strings.HasSuffix(formattedPath, "index.html") {
+				rootPath := strings.TrimSuffix(formattedPath, "/index.html")
+				m[rootPath] = entry
 			}
 
 		}
 		return nil
 	})
-	return m
+	return m, cache
 }
 
-func serveStatic(f files, w http.ResponseWriter, r *http.Request) {
-	sendStatic := func(value *fileInMap) {
+func serveStatic(f files, cache *lruCache, w http.ResponseWriter, r *http.Request) {
+	sendStatic := func(value fileEntry) {
+		// Range requests are seeked and streamed straight off the lazyFile
+		// below; promoting a multi-GB file into memory just to scrub a few
+		// bytes out of it is exactly the OOM --max-cache-bytes exists to
+		// prevent, so only resolve cache residency for full-body requests.
+		if cache != nil && r.Header.Get("Range") == "" {
+			if lf, ok := value.(*lazyFile); ok {
+				value = cache.resolve(lf)
+			}
+		}
+
+		w.Header().Add("Content-Type", value.ContentType())
+
+		cacheable := !*noCache &&
+			value.ContentType() != "text/html" &&
+			value.ContentType() != "text/plain" &&
+			value.ContentType() != "text/markdown" &&
+			!strings.HasSuffix(r.URL.Path, "favicon.ico")
 
-		w.Header().Add("Content-Type", value.contentType)
+		if cacheable {
+			w.Header().Set("Etag", value.ETag())
+			w.Header().Set("Last-Modified", value.ModTime().UTC().Format(http.TimeFormat))
+			w.Header().Set("Cache-Control", "max-age=31536000")
 
-		if !*noCache {
-			if value.contentType != "text/html" &&
-				value.contentType != "text/plain" &&
-				value.contentType != "text/markdown" &&
-				!strings.HasSuffix(r.URL.Path, "favicon.ico") {
-				w.Header().Add("Etag", r.URL.Path)
-				w.Header().Set("Cache-Control", "max-age=31536000")
+			if isNotModified(r, value) {
+				w.WriteHeader(http.StatusNotModified)
+				return
 			}
+		}
 
+		w.Header().Set("Accept-Ranges", "bytes")
+		if rangeHeader := r.Header.Get("Range"); rangeHeader != "" {
+			if sendRange(f, w, value, rangeHeader) {
+				return
+			}
+			// Malformed Range syntax: ignore it and serve the normal response.
 		}
 
-		// Gzip data
-		var b bytes.Buffer
-		gz := gzip.NewWriter(&b)
-		if _, err := gz.Write(value.content); err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding, reader, length, err := openEncoded(r.Header.Get("Accept-Encoding"), value)
+		if err != nil {
+			sendServerError(f, w)
 			return
 		}
-		if err := gz.Close(); err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			return
+		defer reader.Close()
+		if encoding != "" {
+			w.Header().Add("Content-Encoding", encoding)
 		}
-		content := b.Bytes()
-		w.Header().Add("Content-Encoding", "gzip")
-		w.Header().Add("Content-Length", strconv.Itoa(len(content)))
+		w.Header().Add("Content-Length", strconv.FormatInt(length, 10))
 		w.WriteHeader(http.StatusOK)
-		w.Write(content)
+		io.Copy(w, reader)
+	}
+	// notFound is the last resort once no path variant matched: SPA mode
+	// serves the app shell so client-side routing can take over, otherwise
+	// the default or user-supplied 404 page is rendered.
+	notFound := func() {
+		if *spa {
+			if index, ok := f["/index.html"]; ok {
+				sendStatic(index)
+				return
+			}
+		}
+		sendNotFound(f, w)
 	}
 	value, ok := f[r.URL.Path]
 	if !ok {
@@ -177,18 +564,18 @@ func serveStatic(f files, w http.ResponseWriter, r *http.Request) {
 				formatted := strings.TrimSuffix(r.URL.Path, "/")
 				value, ok = f[formatted]
 				if !ok {
-					sendNotFound(w)
+					notFound()
 				} else {
-					sendStatic(&value)
+					sendStatic(value)
 				}
 			} else {
-				sendNotFound(w)
+				notFound()
 			}
 
 		} else {
-			sendStatic(&value)
+			sendStatic(value)
 		}
 	} else {
-		sendStatic(&value)
+		sendStatic(value)
 	}
 }
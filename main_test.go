@@ -0,0 +1,178 @@
+package main
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseByteRanges(t *testing.T) {
+	const size = int64(1000)
+
+	tests := []struct {
+		name   string
+		header string
+		want   []httpRange
+		err    error
+	}{
+		{
+			name:   "single range",
+			header: "bytes=0-499",
+			want:   []httpRange{{start: 0, length: 500}},
+		},
+		{
+			name:   "single range open end",
+			header: "bytes=900-",
+			want:   []httpRange{{start: 900, length: 100}},
+		},
+		{
+			name:   "suffix range",
+			header: "bytes=-500",
+			want:   []httpRange{{start: 500, length: 500}},
+		},
+		{
+			name:   "suffix range larger than size",
+			header: "bytes=-10000",
+			want:   []httpRange{{start: 0, length: 1000}},
+		},
+		{
+			name:   "end clamped to size",
+			header: "bytes=500-10000",
+			want:   []httpRange{{start: 500, length: 500}},
+		},
+		{
+			name:   "multi range",
+			header: "bytes=0-99,200-299",
+			want: []httpRange{
+				{start: 0, length: 100},
+				{start: 200, length: 100},
+			},
+		},
+		{
+			name:   "start beyond size is dropped but others still satisfy",
+			header: "bytes=0-99,5000-",
+			want:   []httpRange{{start: 0, length: 100}},
+		},
+		{
+			name:   "start beyond size with no other ranges is no overlap",
+			header: "bytes=5000-",
+			err:    errNoOverlap,
+		},
+		{
+			name:   "missing bytes prefix",
+			header: "0-499",
+			err:    errInvalidRange,
+		},
+		{
+			name:   "empty header value",
+			header: "bytes=",
+			err:    errNoOverlap,
+		},
+		{
+			name:   "unparseable bounds",
+			header: "bytes=abc-def",
+			err:    errInvalidRange,
+		},
+		{
+			name:   "missing dash",
+			header: "bytes=500",
+			err:    errInvalidRange,
+		},
+		{
+			name:   "inverted bounds",
+			header: "bytes=500-100",
+			err:    errInvalidRange,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseByteRanges(tt.header, size)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("parseByteRanges(%q) error = %v, want %v", tt.header, err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseByteRanges(%q) unexpected error: %v", tt.header, err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("parseByteRanges(%q) = %v, want %v", tt.header, got, tt.want)
+			}
+			for i, r := range got {
+				if r != tt.want[i] {
+					t.Fatalf("parseByteRanges(%q)[%d] = %+v, want %+v", tt.header, i, r, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func newTestMemFile(content string) *memFile {
+	return &memFile{
+		content:     []byte(content),
+		contentType: "text/plain",
+		etag:        `"test"`,
+		fileSize:    int64(len(content)),
+	}
+}
+
+func TestSendRangeSingle(t *testing.T) {
+	value := newTestMemFile("0123456789")
+	w := httptest.NewRecorder()
+	if handled := sendRange(nil, w, value, "bytes=2-4"); !handled {
+		t.Fatal("sendRange reported unhandled for a valid range")
+	}
+	if w.Code != 206 {
+		t.Fatalf("status = %d, want 206", w.Code)
+	}
+	if got := w.Body.String(); got != "234" {
+		t.Fatalf("body = %q, want %q", got, "234")
+	}
+	if got := w.Header().Get("Content-Range"); got != "bytes 2-4/10" {
+		t.Fatalf("Content-Range = %q", got)
+	}
+}
+
+func TestSendRangeMultipart(t *testing.T) {
+	value := newTestMemFile("0123456789")
+	w := httptest.NewRecorder()
+	if handled := sendRange(nil, w, value, "bytes=0-1,5-6"); !handled {
+		t.Fatal("sendRange reported unhandled for a valid multi-range")
+	}
+	if w.Code != 206 {
+		t.Fatalf("status = %d, want 206", w.Code)
+	}
+	ct := w.Header().Get("Content-Type")
+	if !contentTypeIsMultipartByteranges(ct) {
+		t.Fatalf("Content-Type = %q, want multipart/byteranges", ct)
+	}
+}
+
+func contentTypeIsMultipartByteranges(ct string) bool {
+	const want = "multipart/byteranges; boundary="
+	return len(ct) >= len(want) && ct[:len(want)] == want
+}
+
+func TestSendRangeNoOverlap(t *testing.T) {
+	value := newTestMemFile("0123456789")
+	w := httptest.NewRecorder()
+	if handled := sendRange(nil, w, value, "bytes=50-"); !handled {
+		t.Fatal("sendRange reported unhandled for a non-overlapping range")
+	}
+	if w.Code != 416 {
+		t.Fatalf("status = %d, want 416", w.Code)
+	}
+}
+
+func TestSendRangeMalformedFallsThrough(t *testing.T) {
+	value := newTestMemFile("0123456789")
+	w := httptest.NewRecorder()
+	if handled := sendRange(nil, w, value, "bytes=abc-def"); handled {
+		t.Fatal("sendRange reported handled for a malformed Range header")
+	}
+	if w.Code != 200 {
+		t.Fatalf("status = %d, want 200 (nothing written yet)", w.Code)
+	}
+}
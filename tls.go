@@ -0,0 +1,72 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// serve starts handler on the configured listener(s): plain HTTP on --addr,
+// TLS via --tls-cert/--tls-key, or autocert-managed TLS on :443 via
+// --autocert. --redirect-http additionally runs a :80 listener that redirects
+// to the https URL (and, under autocert, answers ACME http-01 challenges).
+func serve(handler http.Handler) {
+	switch {
+	case *autocertDomains != "":
+		domains := splitCSV(*autocertDomains)
+		certManager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(domains...),
+			Cache:      autocert.DirCache(*autocertCache),
+		}
+		if *redirectHTTP {
+			go func() {
+				log.Println("Listening on :80 (http -> https redirect)")
+				log.Fatal(http.ListenAndServe(":80", certManager.HTTPHandler(redirectToHTTPS())))
+			}()
+		}
+		server := &http.Server{
+			Addr:      ":443",
+			Handler:   handler,
+			TLSConfig: certManager.TLSConfig(),
+		}
+		log.Printf("Listening on :443 (autocert for %s)", strings.Join(domains, ", "))
+		log.Fatal(server.ListenAndServeTLS("", ""))
+	case *tlsCert != "" || *tlsKey != "":
+		if *tlsCert == "" || *tlsKey == "" {
+			log.Fatal("--tls-cert and --tls-key must both be set")
+		}
+		if *redirectHTTP {
+			go func() {
+				log.Println("Listening on :80 (http -> https redirect)")
+				log.Fatal(http.ListenAndServe(":80", redirectToHTTPS()))
+			}()
+		}
+		log.Printf("Listening on %s (tls)", *addr)
+		log.Fatal(http.ListenAndServeTLS(*addr, *tlsCert, *tlsKey, handler))
+	default:
+		log.Printf("Listening on %s", *addr)
+		log.Fatal(http.ListenAndServe(*addr, handler))
+	}
+}
+
+// redirectToHTTPS sends every request to the same host and path over https.
+func redirectToHTTPS() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target := "https://" + r.Host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusPermanentRedirect)
+	})
+}
+
+// splitCSV splits a comma-separated flag value into trimmed, non-empty parts.
+func splitCSV(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}